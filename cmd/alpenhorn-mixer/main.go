@@ -13,6 +13,7 @@ import (
 	"os"
 	"text/template"
 
+	"github.com/davidlazar/go-crypto/encoding/base32"
 	"golang.org/x/crypto/ed25519"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
@@ -41,6 +42,10 @@ type Config struct {
 	ListenAddr string
 	LogsDir    string
 
+	// MetricsAddr, if non-empty, serves Prometheus text exposition
+	// of per-round timing and noise metrics. Leave empty to disable.
+	MetricsAddr string
+
 	AddFriendNoise rand.Laplace
 	DialingNoise   rand.Laplace
 }
@@ -57,6 +62,8 @@ privateKey = {{.PrivateKey | base32 | printf "%q"}}
 listenAddr = {{.ListenAddr | printf "%q"}}
 logsDir = {{.LogsDir | printf "%q" }}
 
+# metricsAddr = ":9090"
+
 [addFriendNoise]
 mu = {{.AddFriendNoise.Mu | printf "%0.1f"}}
 b = {{.AddFriendNoise.B | printf "%0.1f"}}
@@ -143,21 +150,27 @@ func main() {
 	}
 	addFriendConfig := signedConfig.Inner.(*config.AddFriendConfig)
 
+	metrics := newMixerMetrics()
+	metrics.coordinator.WithLabelValues(base32.EncodeToString(addFriendConfig.Coordinator.Key)).Set(1)
+	if conf.MetricsAddr != "" {
+		go metrics.serve(conf.MetricsAddr)
+	}
+
 	mixServer := &mixnet.Server{
 		SigningKey: conf.PrivateKey,
 		// Assumes that AddFriend and Dialing use the same coordinator.
 		CoordinatorKey: addFriendConfig.Coordinator.Key,
 
 		Services: map[string]mixnet.MixService{
-			"AddFriend": &addfriend.Mixer{
+			"AddFriend": newInstrumentedService("AddFriend", &addfriend.Mixer{
 				SigningKey: conf.PrivateKey,
 				Laplace:    conf.AddFriendNoise,
-			},
+			}, metrics),
 
-			"Dialing": &dialing.Mixer{
+			"Dialing": newInstrumentedService("Dialing", &dialing.Mixer{
 				SigningKey: conf.PrivateKey,
 				Laplace:    conf.DialingNoise,
-			},
+			}, metrics),
 		},
 	}
 
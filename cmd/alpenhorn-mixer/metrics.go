@@ -0,0 +1,163 @@
+// Copyright 2016 David Lazar. All rights reserved.
+// Use of this source code is governed by the GNU AGPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"vuvuzela.io/alpenhorn/log"
+	"vuvuzela.io/vuvuzela/mixnet"
+)
+
+// roundPhase names a discrete phase of a mixnet round that we time.
+//
+// Only two phases are timed: phaseHandle and phaseCloseRound, the two
+// calls that instrumentedService wraps at the MixService boundary. A
+// round's onion decode, shuffle and noise injection all happen inside
+// the single MixService.Handle call, so they are recorded together as
+// phaseHandle and are not timed separately; forwarding to the next hop
+// happens outside the service and is not timed.
+type roundPhase string
+
+const (
+	phaseHandle     roundPhase = "handle"
+	phaseCloseRound roundPhase = "close_round"
+)
+
+// roundDurationBuckets is tuned for mixnet rounds, which typically
+// take tens of seconds end to end; individual phases range from
+// sub-second to most of a round (the handle phase, which includes the
+// shuffle).
+var roundDurationBuckets = []float64{
+	0.1, 0.5, 1, 2.5, 5, 10, 20, 30, 45, 60, 90, 120,
+}
+
+// mixerMetrics holds the Prometheus collectors exported by the mixer.
+// A single instance is shared by every mixnet.MixService the mixer
+// runs, distinguished by the "service" label (e.g. "AddFriend",
+// "Dialing").
+type mixerMetrics struct {
+	registry *prometheus.Registry
+
+	phaseDuration *prometheus.HistogramVec
+	messages      *prometheus.CounterVec
+	round         *prometheus.GaugeVec
+	coordinator   *prometheus.GaugeVec
+}
+
+func newMixerMetrics() *mixerMetrics {
+	m := &mixerMetrics{
+		phaseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "alpenhorn_mixer",
+			Name:      "round_phase_duration_seconds",
+			Help:      "Time spent in each phase of a mixnet round, per service.",
+			Buckets:   roundDurationBuckets,
+		}, []string{"service", "phase"}),
+
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "alpenhorn_mixer",
+			Name:      "messages_total",
+			Help:      "Messages injected into a round, per service and kind (real or noise).",
+		}, []string{"service", "kind"}),
+
+		round: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "alpenhorn_mixer",
+			Name:      "current_round",
+			Help:      "Most recent round number seen, per service.",
+		}, []string{"service"}),
+
+		coordinator: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "alpenhorn_mixer",
+			Name:      "coordinator_info",
+			Help:      "Set to 1 for the coordinator key this mixer is currently configured to trust.",
+		}, []string{"coordinator_key"}),
+	}
+
+	m.registry = prometheus.NewRegistry()
+	m.registry.MustRegister(m.phaseDuration, m.messages, m.round, m.coordinator)
+	return m
+}
+
+// serve starts the Prometheus text-exposition endpoint on addr. It
+// blocks, so callers should run it in its own goroutine.
+func (m *mixerMetrics) serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	log.Infof("Serving metrics on %q", addr)
+	log.Errorf("metrics server: %s", http.ListenAndServe(addr, mux))
+}
+
+// observePhase records how long phase took for service during a round.
+func (m *mixerMetrics) observePhase(service string, phase roundPhase, seconds float64) {
+	m.phaseDuration.WithLabelValues(service, string(phase)).Observe(seconds)
+}
+
+// addMessages records n messages of kind ("real" or "noise") injected
+// by service into the round. The noise count for a round is drawn
+// from the service's configured rand.Laplace distribution.
+func (m *mixerMetrics) addMessages(service, kind string, n int) {
+	m.messages.WithLabelValues(service, kind).Add(float64(n))
+}
+
+func (m *mixerMetrics) setRound(service string, round uint32) {
+	m.round.WithLabelValues(service).Set(float64(round))
+}
+
+// instrumentedService wraps a mixnet.MixService to record per-round
+// metrics without requiring any changes to the service itself. It
+// delegates every method to the wrapped service, timing Handle and
+// Close and counting messages along the way; any other MixService
+// method is forwarded unmodified through the embedded interface.
+type instrumentedService struct {
+	mixnet.MixService
+	metrics *mixerMetrics
+	service string
+}
+
+// newInstrumentedService wraps inner so that its round handling is
+// recorded under metrics, labeled with service (e.g. "AddFriend" or
+// "Dialing").
+func newInstrumentedService(service string, inner mixnet.MixService, metrics *mixerMetrics) mixnet.MixService {
+	return &instrumentedService{
+		MixService: inner,
+		metrics:    metrics,
+		service:    service,
+	}
+}
+
+// Handle times the service's round processing and records the
+// coordinator-reported round number. The outgoing batch is always at
+// least as large as the incoming one -- the service pads it with
+// Laplace noise before shuffling -- so the difference in batch sizes
+// is exactly the noise the service injected this round.
+func (s *instrumentedService) Handle(settings mixnet.RoundSettings, incoming [][]byte) ([][]byte, error) {
+	s.metrics.setRound(s.service, settings.Round)
+
+	start := time.Now()
+	outgoing, err := s.MixService.Handle(settings, incoming)
+	s.metrics.observePhase(s.service, phaseHandle, time.Since(start).Seconds())
+	if err != nil {
+		return outgoing, err
+	}
+
+	s.metrics.addMessages(s.service, "real", len(incoming))
+	if noise := len(outgoing) - len(incoming); noise > 0 {
+		s.metrics.addMessages(s.service, "noise", noise)
+	}
+
+	return outgoing, err
+}
+
+// Close times the service's end-of-round cleanup before delegating to
+// it.
+func (s *instrumentedService) Close() {
+	start := time.Now()
+	s.MixService.Close()
+	s.metrics.observePhase(s.service, phaseCloseRound, time.Since(start).Seconds())
+}
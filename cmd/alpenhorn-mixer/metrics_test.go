@@ -0,0 +1,99 @@
+// Copyright 2016 David Lazar. All rights reserved.
+// Use of this source code is governed by the GNU AGPL
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"vuvuzela.io/vuvuzela/mixnet"
+)
+
+// fakeMixService is a minimal mixnet.MixService stand-in so
+// instrumentedService's timing and counting logic can be tested
+// without a real addfriend/dialing server.
+type fakeMixService struct {
+	outgoing [][]byte
+	err      error
+	closed   bool
+}
+
+func (f *fakeMixService) Handle(settings mixnet.RoundSettings, incoming [][]byte) ([][]byte, error) {
+	return f.outgoing, f.err
+}
+
+func (f *fakeMixService) Close() {
+	f.closed = true
+}
+
+func TestInstrumentedServiceCountsRealAndNoiseMessages(t *testing.T) {
+	inner := &fakeMixService{
+		outgoing: make([][]byte, 7),
+	}
+	metrics := newMixerMetrics()
+	svc := newInstrumentedService("TestService", inner, metrics)
+
+	incoming := make([][]byte, 3)
+	outgoing, err := svc.Handle(mixnet.RoundSettings{Round: 5}, incoming)
+	if err != nil {
+		t.Fatalf("Handle: %s", err)
+	}
+	if len(outgoing) != 7 {
+		t.Fatalf("got %d outgoing messages, want 7", len(outgoing))
+	}
+
+	if got := testutil.ToFloat64(metrics.messages.WithLabelValues("TestService", "real")); got != 3 {
+		t.Fatalf("real message count = %v, want 3", got)
+	}
+	if got := testutil.ToFloat64(metrics.messages.WithLabelValues("TestService", "noise")); got != 4 {
+		t.Fatalf("noise message count = %v, want 4", got)
+	}
+	if got := testutil.ToFloat64(metrics.round.WithLabelValues("TestService")); got != 5 {
+		t.Fatalf("round gauge = %v, want 5", got)
+	}
+
+	svc.Close()
+	if !inner.closed {
+		t.Fatal("instrumentedService.Close did not delegate to the wrapped service")
+	}
+}
+
+func TestInstrumentedServiceSkipsCountsOnError(t *testing.T) {
+	inner := &fakeMixService{
+		err: errors.New("round failed"),
+	}
+	metrics := newMixerMetrics()
+	svc := newInstrumentedService("TestService", inner, metrics)
+
+	_, err := svc.Handle(mixnet.RoundSettings{Round: 1}, make([][]byte, 3))
+	if err == nil {
+		t.Fatal("Handle did not return the wrapped service's error")
+	}
+
+	if got := testutil.ToFloat64(metrics.messages.WithLabelValues("TestService", "real")); got != 0 {
+		t.Fatalf("real message count = %v, want 0 after a failed round", got)
+	}
+	if got := testutil.ToFloat64(metrics.messages.WithLabelValues("TestService", "noise")); got != 0 {
+		t.Fatalf("noise message count = %v, want 0 after a failed round", got)
+	}
+}
+
+func TestInstrumentedServiceNoNoiseWhenBatchesMatch(t *testing.T) {
+	inner := &fakeMixService{
+		outgoing: make([][]byte, 3),
+	}
+	metrics := newMixerMetrics()
+	svc := newInstrumentedService("TestService", inner, metrics)
+
+	if _, err := svc.Handle(mixnet.RoundSettings{Round: 2}, make([][]byte, 3)); err != nil {
+		t.Fatalf("Handle: %s", err)
+	}
+
+	if got := testutil.ToFloat64(metrics.messages.WithLabelValues("TestService", "noise")); got != 0 {
+		t.Fatalf("noise message count = %v, want 0 when outgoing == incoming", got)
+	}
+}
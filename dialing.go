@@ -131,6 +131,16 @@ func (c *Client) sendDialingOnion(conn typesocket.Conn, v coordinator.MixRound)
 		token := call.computeKeys().token
 		copy(mixMessage.Token[:], token[:])
 		mixMessage.Mailbox = usernameToMailbox(call.Username, serviceData.NumMailboxes)
+	} else if groupCall := c.nextOutgoingGroupCall(round); groupCall != nil {
+		c.mu.Lock()
+		groupCall.sentRound = round
+		c.mu.Unlock()
+
+		c.Handler.SendingGroupCall(groupCall)
+
+		token := groupCall.Group.dialToken(round, c.LongTermPublicKey)
+		copy(mixMessage.Token[:], token[:])
+		mixMessage.Mailbox = groupMailbox(groupCall.Group.ID, serviceData.NumMailboxes)
 	} else {
 		// Send cover traffic.
 		mixMessage.Mailbox = 0
@@ -192,6 +202,40 @@ func (c *Client) scanBloomFilter(conn typesocket.Conn, v coordinator.MailboxURL)
 			}
 		}
 	}
+
+	c.mu.Lock()
+	groups := make(map[GroupID]*Group, len(c.groups))
+	for id, g := range c.groups {
+		groups[id] = g
+	}
+	c.mu.Unlock()
+	groupTokens := c.groupWheel.Tokens(groups, v.Round)
+	for id, byMember := range groupTokens {
+		g := groups[id]
+		groupFilter := filter
+		if groupMailboxID := groupMailbox(id, v.NumMailboxes); groupMailboxID != mailboxID {
+			groupData, err := c.fetchMailbox(st.Config.CDNServer, v.URL, groupMailboxID)
+			if err != nil {
+				c.Handler.Error(errors.Wrap(err, "fetching group mailbox"))
+				continue
+			}
+			groupFilter = new(bloom.Filter)
+			if err := groupFilter.UnmarshalBinary(groupData); err != nil {
+				c.Handler.Error(errors.Wrap(err, "decoding group bloom filter"))
+				continue
+			}
+		}
+		for fromUsername, token := range byMember {
+			if fromUsername == c.Username {
+				continue
+			}
+			if groupFilter.Test(token[:]) {
+				c.Handler.ReceivedGroupCall(g, fromUsername)
+			}
+		}
+	}
+	c.groupWheel.EraseKeys(v.Round)
+
 	c.wheel.EraseKeys(v.Round)
 	if err := c.persistKeywheel(); err != nil {
 		panic(err)
@@ -0,0 +1,58 @@
+// Copyright 2016 David Lazar. All rights reserved.
+// Use of this source code is governed by the GNU AGPL
+// license that can be found in the LICENSE file.
+
+package alpenhorn
+
+import "sync"
+
+// GroupKeywheel caches per-round dial tokens for every member of
+// every active group, mirroring the per-friend Keywheel. Like the
+// Keywheel, it erases a round's cached tokens once they've been used.
+//
+// Unlike the Keywheel, GroupKeywheel holds no secrets of its own:
+// tokens are re-derived from each Group's key on demand, so nothing
+// needs to be persisted to disk. For the same reason, erasing a
+// round's tokens does not stop a client that holds the group key
+// from deriving them again.
+type GroupKeywheel struct {
+	mu     sync.Mutex
+	tokens map[uint32]map[GroupID]map[string][groupTokenSize]byte
+}
+
+// Tokens returns the dial token each member of each group in groups
+// would use during round, computing and caching them as necessary.
+func (w *GroupKeywheel) Tokens(groups map[GroupID]*Group, round uint32) map[GroupID]map[string][groupTokenSize]byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.tokens == nil {
+		w.tokens = make(map[uint32]map[GroupID]map[string][groupTokenSize]byte)
+	}
+	byGroup, ok := w.tokens[round]
+	if !ok {
+		byGroup = make(map[GroupID]map[string][groupTokenSize]byte)
+		w.tokens[round] = byGroup
+	}
+
+	for id, g := range groups {
+		if _, ok := byGroup[id]; ok {
+			continue
+		}
+		byMember := make(map[string][groupTokenSize]byte, len(g.Members))
+		for _, member := range g.Members {
+			byMember[member.Username] = g.dialToken(round, member.LongTermKey)
+		}
+		byGroup[id] = byMember
+	}
+
+	return byGroup
+}
+
+// EraseKeys erases the cached tokens for round. A later call to
+// Tokens for the same round derives them again from the group keys.
+func (w *GroupKeywheel) EraseKeys(round uint32) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.tokens, round)
+}
@@ -0,0 +1,232 @@
+// Copyright 2016 David Lazar. All rights reserved.
+// Use of this source code is governed by the GNU AGPL
+// license that can be found in the LICENSE file.
+
+package alpenhorn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/hkdf"
+
+	"vuvuzela.io/alpenhorn/errors"
+)
+
+// groupTokenSize is the size of a group dial token. It matches the
+// size of the per-friend dial token used by dialing.MixMessage.
+const groupTokenSize = 24
+
+// GroupID identifies a group call across all of its members. It is
+// chosen once, by the group's creator, and never changes.
+type GroupID [16]byte
+
+// GroupKey is the symmetric key shared by every member of a group.
+// It never leaves the device except inside an Invite sent to a
+// friend out-of-band.
+type GroupKey [32]byte
+
+// GroupMember identifies a group participant by the long-term key
+// used when they were added as a friend.
+type GroupMember struct {
+	Username    string
+	LongTermKey ed25519.PublicKey
+}
+
+// Group is a multi-party dialing session: any member can place a
+// group call, and every other member learns that a call happened and
+// who placed it. Groups are formed only from existing friends;
+// Alpenhorn's PKG-based key discovery is not involved in forming one.
+type Group struct {
+	ID        GroupID
+	Key       GroupKey
+	Members   []GroupMember
+	CreatedAt time.Time
+
+	client *Client
+}
+
+// Invite is the out-of-band message a group's creator sends to each
+// friend they want to add. The recipient turns it into a Group with
+// Client.AcceptInvite.
+type Invite struct {
+	ID        GroupID
+	Key       GroupKey
+	Members   []GroupMember
+	CreatedAt time.Time
+}
+
+// Marshal serializes the invite so it can be sent over any existing
+// out-of-band channel.
+func (inv *Invite) Marshal() ([]byte, error) {
+	return json.Marshal(inv)
+}
+
+// UnmarshalInvite parses an invite produced by Invite.Marshal.
+func UnmarshalInvite(data []byte) (*Invite, error) {
+	inv := new(Invite)
+	if err := json.Unmarshal(data, inv); err != nil {
+		return nil, errors.Wrap(err, "unmarshaling invite")
+	}
+	return inv, nil
+}
+
+// CreateGroup creates a new group call with the given friends as
+// members. Groups are formed only from existing friends: each member
+// must already be in the client's friend list, with the long-term key
+// the client recorded when it was added, or CreateGroup returns an
+// error. The client itself is implicitly a member but should not be
+// included in members; CreateGroup adds it to the member list that's
+// shared with every invitee, so that a call the creator places is
+// recognized the same way a call from any other member is.
+func (c *Client) CreateGroup(members []GroupMember) (*Group, error) {
+	var id GroupID
+	if _, err := io.ReadFull(rand.Reader, id[:]); err != nil {
+		return nil, errors.Wrap(err, "generating group id")
+	}
+	var key GroupKey
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		return nil, errors.Wrap(err, "generating group key")
+	}
+
+	c.mu.Lock()
+
+	for _, member := range members {
+		friend, ok := c.friends[member.Username]
+		if !ok || !bytes.Equal(friend.LongTermKey, member.LongTermKey) {
+			c.mu.Unlock()
+			return nil, errors.New("%q is not a friend", member.Username)
+		}
+	}
+
+	allMembers := make([]GroupMember, 0, len(members)+1)
+	allMembers = append(allMembers, members...)
+	allMembers = append(allMembers, GroupMember{
+		Username:    c.Username,
+		LongTermKey: c.LongTermPublicKey,
+	})
+
+	g := &Group{
+		ID:        id,
+		Key:       key,
+		Members:   allMembers,
+		CreatedAt: time.Now(),
+		client:    c,
+	}
+
+	if c.groups == nil {
+		c.groups = make(map[GroupID]*Group)
+	}
+	c.groups[g.ID] = g
+	err := c.persistLocked()
+	c.mu.Unlock()
+	if err != nil {
+		return nil, errors.Wrap(err, "persisting state")
+	}
+
+	return g, nil
+}
+
+// Invite returns the message to send to every member of g so they
+// can accept the group with Client.AcceptInvite.
+func (g *Group) Invite() *Invite {
+	return &Invite{
+		ID:        g.ID,
+		Key:       g.Key,
+		Members:   g.Members,
+		CreatedAt: g.CreatedAt,
+	}
+}
+
+// AcceptInvite adds a group that a friend has invited the client to.
+func (c *Client) AcceptInvite(inv *Invite) (*Group, error) {
+	g := &Group{
+		ID:        inv.ID,
+		Key:       inv.Key,
+		Members:   inv.Members,
+		CreatedAt: inv.CreatedAt,
+		client:    c,
+	}
+
+	c.mu.Lock()
+	if c.groups == nil {
+		c.groups = make(map[GroupID]*Group)
+	}
+	c.groups[g.ID] = g
+	err := c.persistLocked()
+	c.mu.Unlock()
+	if err != nil {
+		return nil, errors.Wrap(err, "persisting state")
+	}
+
+	return g, nil
+}
+
+// OutgoingGroupCall is a placed-but-not-yet-sent call to a group.
+type OutgoingGroupCall struct {
+	Group *Group
+
+	sentRound uint32
+}
+
+// PlaceGroupCall queues a call to g. Queued friend calls are sent
+// first: the group call goes out in the first dialing round the
+// client participates in with no friend call queued.
+func (c *Client) PlaceGroupCall(g *Group) *OutgoingGroupCall {
+	call := &OutgoingGroupCall{
+		Group: g,
+	}
+
+	c.mu.Lock()
+	c.outgoingGroupCalls = append(c.outgoingGroupCalls, call)
+	c.mu.Unlock()
+
+	return call
+}
+
+// nextOutgoingGroupCall pops the next queued group call, if any, the
+// same way nextOutgoingCall pops the next queued friend call.
+func (c *Client) nextOutgoingGroupCall(round uint32) *OutgoingGroupCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var call *OutgoingGroupCall
+	if len(c.outgoingGroupCalls) > 0 {
+		call = c.outgoingGroupCalls[0]
+		c.outgoingGroupCalls = c.outgoingGroupCalls[1:]
+	}
+
+	return call
+}
+
+// dialToken derives the token that member places in the group's
+// mailbox to signal a call from g during round. Every member derives
+// a distinct token for the same round, so a matching token also
+// reveals who placed the call.
+func (g *Group) dialToken(round uint32, member ed25519.PublicKey) [groupTokenSize]byte {
+	info := make([]byte, 4, 4+len(g.ID)+len(member))
+	binary.BigEndian.PutUint32(info, round)
+	info = append(info, g.ID[:]...)
+	info = append(info, member...)
+
+	kdf := hkdf.New(sha256.New, g.Key[:], nil, info)
+	var token [groupTokenSize]byte
+	if _, err := io.ReadFull(kdf, token[:]); err != nil {
+		panic("hkdf: " + err.Error())
+	}
+	return token
+}
+
+// groupMailbox chooses the mailbox a group's dial traffic uses,
+// derived from the group's stable ID so every member agrees on it
+// without coordination.
+func groupMailbox(id GroupID, numMailboxes uint32) uint32 {
+	h := sha256.Sum256(id[:])
+	return binary.BigEndian.Uint32(h[:4]) % numMailboxes
+}
@@ -0,0 +1,86 @@
+// Copyright 2016 David Lazar. All rights reserved.
+// Use of this source code is governed by the GNU AGPL
+// license that can be found in the LICENSE file.
+
+package alpenhorn
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+func newTestGroupMember(t *testing.T, username string) GroupMember {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generating key for %q: %s", username, err)
+	}
+	return GroupMember{
+		Username:    username,
+		LongTermKey: pub,
+	}
+}
+
+func TestGroupDialTokenDiffersPerMember(t *testing.T) {
+	alice := newTestGroupMember(t, "alice")
+	bob := newTestGroupMember(t, "bob")
+
+	g := &Group{
+		ID:      GroupID{1, 2, 3},
+		Key:     GroupKey{4, 5, 6},
+		Members: []GroupMember{alice, bob},
+	}
+
+	round := uint32(7)
+	aliceToken := g.dialToken(round, alice.LongTermKey)
+	bobToken := g.dialToken(round, bob.LongTermKey)
+	if aliceToken == bobToken {
+		t.Fatal("alice and bob derived the same dial token for the same round")
+	}
+
+	// The same member's token must be stable across calls and must
+	// change from round to round, or a mailbox scan could match a
+	// stale call.
+	if g.dialToken(round, alice.LongTermKey) != aliceToken {
+		t.Fatal("dialToken is not deterministic for the same round")
+	}
+	if g.dialToken(round+1, alice.LongTermKey) == aliceToken {
+		t.Fatal("dialToken did not change between rounds")
+	}
+}
+
+func TestGroupKeywheelTokensMatchSenderDerivation(t *testing.T) {
+	alice := newTestGroupMember(t, "alice")
+	bob := newTestGroupMember(t, "bob")
+
+	g := &Group{
+		ID:      GroupID{9, 9, 9},
+		Key:     GroupKey{1, 1, 1},
+		Members: []GroupMember{alice, bob},
+	}
+	groups := map[GroupID]*Group{g.ID: g}
+
+	round := uint32(42)
+
+	// This is what sendDialingOnion computes for the member placing
+	// the call.
+	sentToken := g.dialToken(round, alice.LongTermKey)
+
+	// This is what scanBloomFilter looks up for every other member.
+	wheel := new(GroupKeywheel)
+	byMember := wheel.Tokens(groups, round)[g.ID]
+	recvToken, ok := byMember[alice.Username]
+	if !ok {
+		t.Fatalf("GroupKeywheel.Tokens did not derive a token for %q", alice.Username)
+	}
+	if recvToken != sentToken {
+		t.Fatal("GroupKeywheel.Tokens does not agree with the token a sending member computes")
+	}
+
+	wheel.EraseKeys(round)
+	byMember = wheel.Tokens(groups, round)[g.ID]
+	if byMember[alice.Username] != sentToken {
+		t.Fatal("re-deriving tokens after EraseKeys produced a different token")
+	}
+}
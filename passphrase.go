@@ -0,0 +1,201 @@
+// Copyright 2016 David Lazar. All rights reserved.
+// Use of this source code is governed by the GNU AGPL
+// license that can be found in the LICENSE file.
+
+package alpenhorn
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/nacl/secretbox"
+
+	"vuvuzela.io/alpenhorn/errors"
+)
+
+// sealMagic identifies a persisted file as passphrase-encrypted. It
+// can never collide with the legacy format, which always begins with
+// the '{' of a JSON object.
+var sealMagic = [4]byte{'A', 'H', 'S', '1'}
+
+const sealVersion = 1
+
+// Default Argon2id parameters for deriving a persistence key from a
+// passphrase. These favor the recommended minimums from the Argon2
+// RFC; callers storing especially sensitive state may want to tune
+// them with a slower KDF profile.
+const (
+	defaultKDFTime    = 1
+	defaultKDFMemory  = 64 * 1024 // KiB
+	defaultKDFThreads = 4
+)
+
+const (
+	kdfSaltSize = 16
+	sealKeySize = 32
+)
+
+// Unlocker supplies the passphrase used to encrypt and decrypt a
+// client's persisted state. Implementations typically prompt the
+// user, but may also read from an environment variable or a secret
+// store.
+type Unlocker interface {
+	Unlock() (passphrase []byte, err error)
+}
+
+// PassphraseFunc adapts a function to an Unlocker.
+type PassphraseFunc func() ([]byte, error)
+
+// Unlock calls f.
+func (f PassphraseFunc) Unlock() ([]byte, error) {
+	return f()
+}
+
+type kdfParams struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	Version uint32
+}
+
+func (p *kdfParams) deriveKey(passphrase, salt []byte) *[sealKeySize]byte {
+	raw := argon2.IDKey(passphrase, salt, p.Time, p.Memory, p.Threads, sealKeySize)
+	var key [sealKeySize]byte
+	copy(key[:], raw)
+	return &key
+}
+
+// sealState encrypts data (the marshaled persistedState or keywheel)
+// under a key derived from the passphrase returned by unlock.
+func sealState(data []byte, unlock Unlocker) ([]byte, error) {
+	passphrase, err := unlock.Unlock()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading passphrase")
+	}
+
+	params := kdfParams{
+		Time:    defaultKDFTime,
+		Memory:  defaultKDFMemory,
+		Threads: defaultKDFThreads,
+		Version: argon2.Version,
+	}
+
+	var salt [kdfSaltSize]byte
+	if _, err := io.ReadFull(rand.Reader, salt[:]); err != nil {
+		return nil, errors.Wrap(err, "generating salt")
+	}
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, errors.Wrap(err, "generating nonce")
+	}
+
+	key := params.deriveKey(passphrase, salt[:])
+
+	out := make([]byte, 0, 4+1+4*3+kdfSaltSize+24+len(data)+secretbox.Overhead)
+	out = append(out, sealMagic[:]...)
+	out = append(out, sealVersion)
+	out = appendUint32(out, params.Time)
+	out = appendUint32(out, params.Memory)
+	out = append(out, params.Threads)
+	out = appendUint32(out, params.Version)
+	out = append(out, salt[:]...)
+	out = append(out, nonce[:]...)
+	out = secretbox.Seal(out, data, &nonce, key)
+
+	return out, nil
+}
+
+// isSealed reports whether data is in the passphrase-encrypted format
+// produced by sealState, as opposed to the legacy plaintext JSON.
+func isSealed(data []byte) bool {
+	return len(data) >= len(sealMagic) && string(data[:len(sealMagic)]) == string(sealMagic[:])
+}
+
+// openState decrypts data produced by sealState using the passphrase
+// returned by unlock.
+func openState(data []byte, unlock Unlocker) ([]byte, error) {
+	if !isSealed(data) {
+		return nil, errors.New("openState: not a sealed persistence file")
+	}
+	rest := data[len(sealMagic):]
+
+	if len(rest) < 1 || rest[0] != sealVersion {
+		return nil, errors.New("openState: unsupported format version")
+	}
+	rest = rest[1:]
+
+	var params kdfParams
+	rest, params.Time = readUint32(rest)
+	rest, params.Memory = readUint32(rest)
+	if len(rest) < 1 {
+		return nil, errors.New("openState: truncated header")
+	}
+	params.Threads = rest[0]
+	rest = rest[1:]
+	rest, params.Version = readUint32(rest)
+
+	if len(rest) < kdfSaltSize+24 {
+		return nil, errors.New("openState: truncated header")
+	}
+	salt := rest[:kdfSaltSize]
+	rest = rest[kdfSaltSize:]
+	var nonce [24]byte
+	copy(nonce[:], rest[:24])
+	ciphertext := rest[24:]
+
+	passphrase, err := unlock.Unlock()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading passphrase")
+	}
+	key := params.deriveKey(passphrase, salt)
+
+	plaintext, ok := secretbox.Open(nil, ciphertext, &nonce, key)
+	if !ok {
+		return nil, errors.New("openState: wrong passphrase or corrupt file")
+	}
+	return plaintext, nil
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func readUint32(b []byte) ([]byte, uint32) {
+	if len(b) < 4 {
+		return b, 0
+	}
+	return b[4:], binary.BigEndian.Uint32(b[:4])
+}
+
+// ChangePassphrase re-encrypts the client and keywheel persistence
+// files under a new passphrase, verifying the old one first. Both
+// files are rewritten atomically, the same way a normal Persist is.
+func (c *Client) ChangePassphrase(old, new Unlocker) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ClientPersistPath != "" {
+		data, err := ioutil.ReadFile(c.ClientPersistPath)
+		if err != nil {
+			return errors.Wrap(err, "reading client persistence file")
+		}
+		if isSealed(data) {
+			if _, err := openState(data, old); err != nil {
+				return errors.Wrap(err, "verifying old passphrase")
+			}
+		}
+	}
+
+	previous := c.Unlocker
+	c.Unlocker = new
+	if err := c.persistLocked(); err != nil {
+		c.Unlocker = previous
+		return errors.Wrap(err, "persisting state under new passphrase")
+	}
+	return nil
+}
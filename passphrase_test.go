@@ -0,0 +1,66 @@
+// Copyright 2016 David Lazar. All rights reserved.
+// Use of this source code is governed by the GNU AGPL
+// license that can be found in the LICENSE file.
+
+package alpenhorn
+
+import "testing"
+
+func unlockWith(passphrase string) Unlocker {
+	return PassphraseFunc(func() ([]byte, error) {
+		return []byte(passphrase), nil
+	})
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	plaintext := []byte(`{"Username":"alice"}`)
+
+	sealed, err := sealState(plaintext, unlockWith("correct horse battery staple"))
+	if err != nil {
+		t.Fatalf("sealState: %s", err)
+	}
+	if !isSealed(sealed) {
+		t.Fatal("sealed data does not carry the seal magic")
+	}
+
+	opened, err := openState(sealed, unlockWith("correct horse battery staple"))
+	if err != nil {
+		t.Fatalf("openState: %s", err)
+	}
+	if string(opened) != string(plaintext) {
+		t.Fatalf("round trip mismatch: got %q, want %q", opened, plaintext)
+	}
+}
+
+func TestOpenStateWrongPassphrase(t *testing.T) {
+	sealed, err := sealState([]byte("secret state"), unlockWith("right passphrase"))
+	if err != nil {
+		t.Fatalf("sealState: %s", err)
+	}
+
+	_, err = openState(sealed, unlockWith("wrong passphrase"))
+	if err == nil {
+		t.Fatal("openState succeeded with the wrong passphrase")
+	}
+}
+
+func TestOpenStateTruncatedHeader(t *testing.T) {
+	sealed, err := sealState([]byte("secret state"), unlockWith("passphrase"))
+	if err != nil {
+		t.Fatalf("sealState: %s", err)
+	}
+
+	for _, n := range []int{0, len(sealMagic), len(sealMagic) + 1, len(sealMagic) + 10} {
+		truncated := sealed[:n]
+		if _, err := openState(truncated, unlockWith("passphrase")); err == nil {
+			t.Fatalf("openState did not reject a %d-byte truncated file", n)
+		}
+	}
+}
+
+func TestIsSealedRejectsLegacyPlaintext(t *testing.T) {
+	legacy := []byte(`{"Username":"alice"}`)
+	if isSealed(legacy) {
+		t.Fatal("legacy plaintext JSON was misdetected as sealed")
+	}
+}
@@ -7,7 +7,9 @@ package alpenhorn
 import (
 	"encoding/json"
 	"io/ioutil"
+	"time"
 
+	"vuvuzela.io/alpenhorn/errors"
 	"vuvuzela.io/alpenhorn/internal/ioutil2"
 	"vuvuzela.io/alpenhorn/pkg"
 
@@ -26,6 +28,16 @@ type persistedState struct {
 	SentFriendRequests     []*sentFriendRequest
 	Friends                map[string]*persistedFriend
 	Registrations          map[string]*pkg.Client
+	Groups                 map[GroupID]*persistedGroup
+}
+
+// persistedGroup is the persisted representation of the Group type.
+// We don't persist the client pointer; it's set again on load.
+type persistedGroup struct {
+	ID        GroupID
+	Key       GroupKey
+	Members   []GroupMember
+	CreatedAt time.Time
 }
 
 // persistedFriend is the persisted representation of the Friend type.
@@ -38,12 +50,40 @@ type persistedFriend struct {
 
 // LoadClient loads a client from persisted state at the given path.
 // You should set the client's KeywheelPersistPath before connecting.
+//
+// LoadClient cannot load a client whose persisted state is
+// passphrase-encrypted; use LoadClientWithPassphrase instead.
 func LoadClient(clientPersistPath string) (*Client, error) {
+	return loadClient(clientPersistPath, nil)
+}
+
+// LoadClientWithPassphrase loads a client the same way LoadClient
+// does, but also handles persisted state that was encrypted with a
+// passphrase (see Client.Unlocker). unlock is consulted only if the
+// file is actually encrypted, so it's safe to pass the same unlock
+// function used for every client regardless of whether any given
+// client has opted into encryption yet.
+func LoadClientWithPassphrase(clientPersistPath string, unlock Unlocker) (*Client, error) {
+	return loadClient(clientPersistPath, unlock)
+}
+
+func loadClient(clientPersistPath string, unlock Unlocker) (*Client, error) {
 	clientData, err := ioutil.ReadFile(clientPersistPath)
 	if err != nil {
 		return nil, err
 	}
 
+	sealed := isSealed(clientData)
+	if sealed {
+		if unlock == nil {
+			return nil, errors.New("%q is passphrase-encrypted; use LoadClientWithPassphrase", clientPersistPath)
+		}
+		clientData, err = openState(clientData, unlock)
+		if err != nil {
+			return nil, errors.Wrap(err, "decrypting persisted state")
+		}
+	}
+
 	st := new(persistedState)
 	err = json.Unmarshal(clientData, st)
 	if err != nil {
@@ -53,6 +93,9 @@ func LoadClient(clientPersistPath string) (*Client, error) {
 	c := &Client{
 		ClientPersistPath: clientPersistPath,
 	}
+	if sealed {
+		c.Unlocker = unlock
+	}
 	c.loadStateLocked(st)
 	return c, nil
 }
@@ -89,6 +132,17 @@ func (c *Client) loadStateLocked(st *persistedState) {
 	}
 
 	c.registrations = st.Registrations
+
+	c.groups = make(map[GroupID]*Group, len(st.Groups))
+	for id, g := range st.Groups {
+		c.groups[id] = &Group{
+			ID:        g.ID,
+			Key:       g.Key,
+			Members:   g.Members,
+			CreatedAt: g.CreatedAt,
+			client:    c,
+		}
+	}
 }
 
 // Persist writes the client's state to disk. The client persists
@@ -131,6 +185,7 @@ func (c *Client) persistClient() error {
 
 		Friends:       make(map[string]*persistedFriend, len(c.friends)),
 		Registrations: c.registrations,
+		Groups:        make(map[GroupID]*persistedGroup, len(c.groups)),
 	}
 
 	for username, friend := range c.friends {
@@ -141,11 +196,27 @@ func (c *Client) persistClient() error {
 		}
 	}
 
+	for id, g := range c.groups {
+		st.Groups[id] = &persistedGroup{
+			ID:        g.ID,
+			Key:       g.Key,
+			Members:   g.Members,
+			CreatedAt: g.CreatedAt,
+		}
+	}
+
 	data, err := json.MarshalIndent(st, "", "  ")
 	if err != nil {
 		return err
 	}
 
+	if c.Unlocker != nil {
+		data, err = sealState(data, c.Unlocker)
+		if err != nil {
+			return errors.Wrap(err, "encrypting persisted state")
+		}
+	}
+
 	return ioutil2.WriteFileAtomic(c.ClientPersistPath, data, 0600)
 }
 
@@ -155,5 +226,12 @@ func (c *Client) persistKeywheel() error {
 		return err
 	}
 
+	if c.Unlocker != nil {
+		data, err = sealState(data, c.Unlocker)
+		if err != nil {
+			return errors.Wrap(err, "encrypting keywheel state")
+		}
+	}
+
 	return ioutil2.WriteFileAtomic(c.KeywheelPersistPath, data, 0600)
-}
\ No newline at end of file
+}